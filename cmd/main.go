@@ -7,7 +7,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -24,22 +26,48 @@ func main() {
 	var (
 		destDir             string
 		format              string
+		diffFormat          string
+		allowlistPath       string
 		envStr              string
 		deploymentStr       string
 		customEntriesPath   string
 		customEntriesFormat string
+		mode                string
+		debounceInterval    time.Duration
+		metricsAddr         string
+		leaderElectionNS    string
+		leaderElectionID    string
 		printFn             func(m types.ComMatrix) ([]byte, error)
+		diffPrintFn         func(d types.MatrixDiff) ([]byte, error)
 	)
 
 	flag.StringVar(&destDir, "destDir", "communication-matrix", "Output files dir")
 	flag.StringVar(&format, "format", "csv", "Desired format (json,yaml,csv)")
-	flag.StringVar(&envStr, "env", "baremetal", "Cluster environment (baremetal/aws)")
+	flag.StringVar(&diffFormat, "diffFormat", "csv", "Desired format for the matrix diff (json,yaml,csv)")
+	flag.StringVar(&allowlistPath, "allowlistPath", "", "Path to a file listing known-noise services (one per line) to exclude from the unexpected-open classification")
+	flag.StringVar(&envStr, "env", "", fmt.Sprintf("Cluster environment, one of %v (empty to auto-detect from the Infrastructure CR)", commatrix.RegisteredProviders()))
 	flag.StringVar(&deploymentStr, "deployment", "mno", "Deployment type (mno/sno)")
 	flag.StringVar(&customEntriesPath, "customEntriesPath", "", "Add custom entries from a file to the matrix")
 	flag.StringVar(&customEntriesFormat, "customEntriesFormat", "", "Set the format of the custom entries file (json,yaml,csv)")
+	flag.StringVar(&mode, "mode", "oneshot", "Run mode: oneshot (generate the matrix and exit) or controller (watch the cluster and reconcile continuously)")
+	flag.DurationVar(&debounceInterval, "debounceInterval", 5*time.Second, "Controller mode: time to wait after the last cluster event before reconciling")
+	flag.StringVar(&metricsAddr, "metricsAddr", ":8081", "Controller mode: address to serve /metrics and /healthz on")
+	flag.StringVar(&leaderElectionNS, "leaderElectionNamespace", "commatrix", "Controller mode: namespace for the leader-election lease")
+	flag.StringVar(&leaderElectionID, "leaderElectionID", "commatrix-controller", "Controller mode: name of the leader-election lease")
 
 	flag.Parse()
 
+	switch diffFormat {
+	case "json":
+		diffPrintFn = types.ToDiffJSON
+	case "csv":
+		diffPrintFn = types.ToDiffCSV
+	case "yaml":
+		diffPrintFn = types.ToDiffYAML
+	default:
+		panic(fmt.Sprintf("invalid diffFormat: %s. Please specify json, csv, or yaml.", diffFormat))
+	}
+
 	switch format {
 	case "json":
 		printFn = types.ToJSON
@@ -56,16 +84,6 @@ func main() {
 		panic("must set the KUBECONFIG environment variable")
 	}
 
-	var env commatrix.Env
-	switch envStr {
-	case "baremetal":
-		env = commatrix.Baremetal
-	case "aws":
-		env = commatrix.AWS
-	default:
-		panic(fmt.Sprintf("invalid cluster environment: %s", envStr))
-	}
-
 	var deployment commatrix.Deployment
 	switch deploymentStr {
 	case "mno":
@@ -80,7 +98,33 @@ func main() {
 		panic("error, variable customEntriesFormat is not set")
 	}
 
-	mat, err := commatrix.New(kubeconfig, customEntriesPath, customEntriesFormat, env, deployment)
+	if mode == "controller" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := commatrix.Run(ctx, commatrix.Options{
+			KubeconfigPath:          kubeconfig,
+			CustomEntriesPath:       customEntriesPath,
+			CustomEntriesFormat:     customEntriesFormat,
+			Env:                     envStr,
+			Deployment:              deployment,
+			DebounceInterval:        debounceInterval,
+			MetricsAddr:             metricsAddr,
+			LeaderElectionNamespace: leaderElectionNS,
+			LeaderElectionID:        leaderElectionID,
+		})
+		if err != nil {
+			panic(fmt.Sprintf("controller mode stopped: %s", err))
+		}
+
+		return
+	}
+
+	if mode != "oneshot" {
+		panic(fmt.Sprintf("invalid mode: %s. Please specify oneshot or controller.", mode))
+	}
+
+	mat, err := commatrix.New(kubeconfig, customEntriesPath, customEntriesFormat, envStr, deployment)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create the communication matrix: %s", err))
 	}
@@ -177,27 +221,31 @@ func main() {
 		panic(err)
 	}
 
-	diff := buildMatrixDiff(*mat, ssComMat)
-
-	err = os.WriteFile(filepath.Join(destDir, "matrix-diff-ss"),
-		[]byte(diff),
-		0644)
+	allowlist, err := loadAllowlist(allowlistPath)
 	if err != nil {
 		panic(err)
 	}
 
-	err = commatrix.ApplyFireWallRules(cs, mat, "master")
+	diff := types.NewMatrixDiff(*mat, ssComMat, allowlist)
+
+	diffRes, err := diffPrintFn(diff)
 	if err != nil {
 		panic(err)
 	}
-	nftWorker := types.ToNFTables(*mat, "worker")
+
+	matrixDiffFileName := filepath.Join(destDir, fmt.Sprintf("matrix-diff-ss.%s", diffFormat))
+	err = os.WriteFile(matrixDiffFileName, diffRes, 0644)
 	if err != nil {
 		panic(err)
 	}
-	nftMaster := types.ToNFTables(*mat, "master")
-	if err != nil {
+
+	if _, err := commatrix.ApplyFireWallRules(cs, mat, "master"); err != nil {
 		panic(err)
 	}
+	nftWorker := types.ToNFTables(*mat, "worker", types.IPv4)
+	nftMaster := types.ToNFTables(*mat, "master", types.IPv4)
+	nftWorkerIP6 := types.ToNFTables(*mat, "worker", types.IPv6)
+	nftMasterIP6 := types.ToNFTables(*mat, "master", types.IPv6)
 
 	err = os.WriteFile(filepath.Join(destDir, "nft-file-worker"),
 		nftWorker,
@@ -212,30 +260,50 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+
+	err = os.WriteFile(filepath.Join(destDir, "nft-file-worker-ip6"),
+		nftWorkerIP6,
+		0644)
+	if err != nil {
+		panic(err)
+	}
+
+	err = os.WriteFile(filepath.Join(destDir, "nft-file-master-ip6"),
+		nftMasterIP6,
+		0644)
+	if err != nil {
+		panic(err)
+	}
+
+	// An unexpected-open entry means a node is listening on a port nothing
+	// in the expected matrix accounts for; fail the run so this is usable
+	// as a compliance gate in CI.
+	if diff.HasSeverity(types.SeverityUnexpectedOpen) {
+		fmt.Fprintf(os.Stderr, "commatrix: unexpected open ports found, see %s\n", matrixDiffFileName)
+		os.Exit(1)
+	}
 }
 
-func buildMatrixDiff(mat1 types.ComMatrix, mat2 types.ComMatrix) string {
-	diff := consts.CSVHeaders + "\n"
-	for _, cd := range mat1.Matrix {
-		if mat2.Contains(cd) {
-			diff += fmt.Sprintf("%s\n", cd)
-			continue
-		}
+// loadAllowlist reads known-noise service names, one per line, from fp.
+// An empty fp yields an empty allowlist.
+func loadAllowlist(fp string) ([]string, error) {
+	if fp == "" {
+		return nil, nil
+	}
 
-		diff += fmt.Sprintf("+ %s\n", cd)
+	raw, err := os.ReadFile(filepath.Clean(fp))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowlist file %s: %w", fp, err)
 	}
 
-	for _, cd := range mat2.Matrix {
-		// Skip "rpc.statd" ports, these are randomly open ports on the node,
-		// no need to mention them in the matrix diff
-		if cd.Service == "rpc.statd" {
+	var allowlist []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-
-		if !mat1.Contains(cd) {
-			diff += fmt.Sprintf("- %s\n", cd)
-		}
+		allowlist = append(allowlist, line)
 	}
 
-	return diff
+	return allowlist, nil
 }
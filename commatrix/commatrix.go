@@ -1,12 +1,15 @@
 package commatrix
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 
 	"github.com/gocarina/gocsv"
 	"golang.org/x/sync/errgroup"
@@ -17,19 +20,13 @@ import (
 	"github.com/openshift-kni/commatrix/consts"
 	"github.com/openshift-kni/commatrix/debug"
 	"github.com/openshift-kni/commatrix/endpointslices"
+	"github.com/openshift-kni/commatrix/hostnet"
 	nodesutil "github.com/openshift-kni/commatrix/nodes"
 	"github.com/openshift-kni/commatrix/types"
 )
 
 // TODO: add integration tests.
 
-type Env int
-
-const (
-	Baremetal Env = iota
-	AWS
-)
-
 type Deployment int
 
 const (
@@ -40,10 +37,13 @@ const (
 // New initializes a ComMatrix using Kubernetes cluster data.
 // It takes kubeconfigPath for cluster access to  fetch EndpointSlice objects,
 // detailing open ports for ingress traffic.
+// envName selects a registered EnvironmentProvider by name (see Register);
+// pass an empty string to auto-detect the provider from the cluster's
+// Infrastructure CR.
 // Custom entries from a JSON file can be added to the matrix by setting `customEntriesPath`.
 // Returns a pointer to ComMatrix and error. Entries include traffic direction, protocol,
 // port number, namespace, service name, pod, container, node role, and flow optionality for OpenShift.
-func New(kubeconfigPath string, customEntriesPath string, customEntriesFormat string, e Env, d Deployment) (*types.ComMatrix, error) {
+func New(kubeconfigPath string, customEntriesPath string, customEntriesFormat string, envName string, d Deployment) (*types.ComMatrix, error) {
 	res := make([]types.ComDetails, 0)
 
 	cs, err := client.New(kubeconfigPath)
@@ -62,7 +62,19 @@ func New(kubeconfigPath string, customEntriesPath string, customEntriesFormat st
 	}
 	res = append(res, epSliceComDetails...)
 
-	staticEntries, err := getStaticEntries(e, d)
+	hostnetComDetails, err := hostnet.GetHostNetworkComDetails(cs)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting host-network pods: %w", err)
+	}
+	res = append(res, hostnetComDetails...)
+
+	egressComDetails, err := endpointslices.GetEgressComDetails(cs)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting egress traffic: %w", err)
+	}
+	res = append(res, egressComDetails...)
+
+	staticEntries, err := getStaticEntries(cs, envName, d)
 	if err != nil {
 		return nil, fmt.Errorf("failed adding static entries: %s", err)
 	}
@@ -87,32 +99,28 @@ func New(kubeconfigPath string, customEntriesPath string, customEntriesFormat st
 	return &types.ComMatrix{Matrix: cleanedComDetails}, nil
 }
 
-func ApplyFireWallRules(cs *client.ClientSet, m *types.ComMatrix, role string) error {
-	tcp := ""
-	udp := ""
-
-	for _, cd := range m.Matrix {
-		if cd.NodeRole != role {
-			continue
-		}
-		if cd.Protocol == "TCP" {
-			tcp += fmt.Sprint(cd.Port) + ", "
-		}
-		if cd.Protocol == "UDP" {
-			udp += fmt.Sprint(cd.Port) + ", "
-		}
-	}
-
-	// Remove the trailing ", " substring
-	tcpPorts := tcp[:len(tcp)-2]
-	udpPorts := udp[:len(udp)-2]
+// ApplyFireWallRules renders and applies the nft firewall ruleset for role on
+// every matching node concurrently, returning each node's pre-apply ruleset
+// for use with RollbackFirewall. Once any node's apply fails, nodes that
+// haven't started their own apply yet are skipped; a node already mid-apply
+// still runs to completion, since debug.DebugPod.Exec has no cancellable
+// context.
+func ApplyFireWallRules(cs *client.ClientSet, m *types.ComMatrix, role string) (map[string][]byte, error) {
+	ruleset := renderFireWallRuleset(m, role)
 
 	nodesList, err := cs.CoreV1Interface.Nodes().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed listing nodes: %w", err)
 	}
 
-	g := new(errgroup.Group)
+	preImages := make(map[string][]byte)
+	var preImagesMu sync.Mutex
+
+	// debug.DebugPod.Exec takes no context, so a node already mid-apply
+	// can't be interrupted; errgroup.WithContext still buys fail-fast for
+	// every node whose goroutine hasn't started its exec calls yet by the
+	// time a sibling's error cancels groupCtx.
+	g, groupCtx := errgroup.WithContext(context.Background())
 	for _, n := range nodesList.Items {
 		node := n
 		nodeRole := nodesutil.GetRole(&node)
@@ -121,6 +129,10 @@ func ApplyFireWallRules(cs *client.ClientSet, m *types.ComMatrix, role string) e
 		}
 
 		g.Go(func() error {
+			if groupCtx.Err() != nil {
+				return groupCtx.Err()
+			}
+
 			debugPod, err := debug.New(cs, node.Name, consts.DefaultDebugNamespace, consts.DefaultDebugPodImage)
 			if err != nil {
 				return err
@@ -131,62 +143,158 @@ func ApplyFireWallRules(cs *client.ClientSet, m *types.ComMatrix, role string) e
 					fmt.Printf("failed cleaning debug pod %s: %v", debugPod, err)
 				}
 			}()
-			_, err = debugPod.Exec("sudo nft add chain ip filter FIREWALL")
-			if err != nil {
-				return err
-			}
-			_, err = debugPod.Exec("sudo nft add rule ip filter FIREWALL iif lo accept")
-			if err != nil {
-				return err
-			}
-			_, err = debugPod.Exec("sudo nft add rule ip filter FIREWALL ct state established,related accept")
-			if err != nil {
-				return err
-			}
-			_, err = debugPod.Exec("sudo nft add rule ip filter FIREWALL tcp dport { 22 } accept")
-			if err != nil {
-				return err
-			}
 
-			_, err = debugPod.Exec("sudo nft add rule ip filter FIREWALL udp dport { 67, 68 }  accept")
+			preImage, err := debugPod.Exec("sudo nft list ruleset")
 			if err != nil {
-				return err
+				return fmt.Errorf("failed capturing pre-apply ruleset on node %s: %w", node.Name, err)
 			}
+			preImagesMu.Lock()
+			preImages[node.Name] = []byte(preImage)
+			preImagesMu.Unlock()
 
-			_, err = debugPod.Exec("sudo nft add rule ip filter FIREWALL ip protocol icmp accept")
-			if err != nil {
-				return err
+			if groupCtx.Err() != nil {
+				return groupCtx.Err()
 			}
 
-			_, err = debugPod.Exec(fmt.Sprintf("sudo nft add rule ip filter FIREWALL tcp dport { %s } accept", tcpPorts))
-			if err != nil {
-				return err
-			}
+			return applyRuleset(debugPod, ruleset)
+		})
+	}
 
-			_, err = debugPod.Exec(fmt.Sprintf("sudo nft add rule ip filter FIREWALL udp dport { %s } accept", udpPorts))
-			if err != nil {
-				return err
+	if err := g.Wait(); err != nil {
+		return preImages, err
+	}
+
+	return preImages, nil
+}
+
+// DryRunFirewall renders the nft ruleset ApplyFireWallRules would apply for
+// role, without touching any node. Useful for reviewing a change before
+// rolling it out, or for diffing against a previously applied ruleset.
+func DryRunFirewall(m *types.ComMatrix, role string) []byte {
+	return renderFireWallRuleset(m, role)
+}
+
+// RollbackFirewall restores preImage - a per-node ruleset previously
+// captured with `nft list ruleset`, as returned by ApplyFireWallRules - on
+// every node with the given role. As with ApplyFireWallRules, a failure
+// only skips nodes whose own restore hasn't started yet.
+func RollbackFirewall(cs *client.ClientSet, role string, preImage map[string][]byte) error {
+	nodesList, err := cs.CoreV1Interface.Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed listing nodes: %w", err)
+	}
+
+	g, groupCtx := errgroup.WithContext(context.Background())
+	for _, n := range nodesList.Items {
+		node := n
+		nodeRole := nodesutil.GetRole(&node)
+		if nodeRole != role {
+			continue
+		}
+
+		ruleset, ok := preImage[node.Name]
+		if !ok {
+			continue
+		}
+
+		g.Go(func() error {
+			if groupCtx.Err() != nil {
+				return groupCtx.Err()
 			}
-			_, err = debugPod.Exec("sudo nft add rule ip filter FIREWALL log prefix firewall drop")
+
+			debugPod, err := debug.New(cs, node.Name, consts.DefaultDebugNamespace, consts.DefaultDebugPodImage)
 			if err != nil {
 				return err
 			}
-			_, err = debugPod.Exec("sudo nft add rule ip filter INPUT jump FIREWALL")
-			if err != nil {
-				return err
+			defer func() {
+				err := debugPod.Clean()
+				if err != nil {
+					fmt.Printf("failed cleaning debug pod %s: %v", debugPod, err)
+				}
+			}()
+
+			if groupCtx.Err() != nil {
+				return groupCtx.Err()
 			}
-			return nil
+
+			// `nft -f` on a bare `nft list ruleset` dump adds to the live
+			// ruleset; flushing first in the same file replaces it atomically.
+			restore := append([]byte("flush ruleset\n"), ruleset...)
+
+			return applyRuleset(debugPod, restore)
 		})
 	}
 
-	err = g.Wait()
+	return g.Wait()
+}
+
+// nftRulesetPath is where the rendered ruleset is written inside the debug
+// pod before being applied with a single `nft -f`.
+const nftRulesetPath = "/tmp/commatrix-firewall.nft"
+
+// applyRuleset writes ruleset into the debug pod and applies it atomically.
+// Because the ruleset starts with `flush chain`/`add chain` guards for every
+// chain it touches, re-applying it is idempotent and never leaves a
+// half-built FIREWALL chain behind.
+func applyRuleset(debugPod *debug.DebugPod, ruleset []byte) error {
+	writeCmd := fmt.Sprintf("cat > %s <<'EOF'\n%sEOF", nftRulesetPath, ruleset)
+	_, err := debugPod.Exec(writeCmd)
+	if err != nil {
+		return fmt.Errorf("failed writing nft ruleset: %w", err)
+	}
+
+	_, err = debugPod.Exec(fmt.Sprintf("sudo nft -f %s", nftRulesetPath))
 	if err != nil {
-		return err
+		return fmt.Errorf("failed applying nft ruleset: %w", err)
 	}
 
 	return nil
 }
 
+// renderFireWallRuleset builds the complete nft ruleset document for role,
+// covering both the ip and ip6 families, with types.ToNFTables as the
+// source of truth for the per-family accept rules. Every chain it manages
+// is guarded with `add chain`/`flush chain` so the document can be applied
+// repeatedly without duplicating rules.
+func renderFireWallRuleset(m *types.ComMatrix, role string) []byte {
+	var buf bytes.Buffer
+
+	for _, family := range []types.IPFamily{types.IPv4, types.IPv6} {
+		table := "ip filter"
+		icmpRule := "ip protocol icmp accept"
+		dhcpPorts := "67, 68"
+		if family == types.IPv6 {
+			table = "ip6 filter"
+			icmpRule = "icmpv6 type { nd-router-solicit, nd-router-advert, nd-neighbor-solicit, nd-neighbor-advert } accept"
+			dhcpPorts = "546, 547"
+		}
+
+		// FIREWALL/FIREWALL-EGRESS hook directly into input/output instead
+		// of being jumped to from the base chain: re-applying this ruleset
+		// never appends a duplicate jump rule, since `add chain` with an
+		// unchanged hook spec and `flush chain` are both idempotent.
+		fmt.Fprintf(&buf, "add chain %s FIREWALL { type filter hook input priority 0 ; policy accept ; }\n", table)
+		fmt.Fprintf(&buf, "flush chain %s FIREWALL\n", table)
+		fmt.Fprintf(&buf, "add chain %s FIREWALL-EGRESS { type filter hook output priority 0 ; policy accept ; }\n", table)
+		fmt.Fprintf(&buf, "flush chain %s FIREWALL-EGRESS\n", table)
+
+		fmt.Fprintf(&buf, "add rule %s FIREWALL iif lo accept\n", table)
+		fmt.Fprintf(&buf, "add rule %s FIREWALL ct state established,related accept\n", table)
+		fmt.Fprintf(&buf, "add rule %s FIREWALL tcp dport { 22 } accept\n", table)
+		fmt.Fprintf(&buf, "add rule %s FIREWALL udp dport { %s } accept\n", table, dhcpPorts)
+		fmt.Fprintf(&buf, "add rule %s FIREWALL %s\n", table, icmpRule)
+
+		fmt.Fprintf(&buf, "add rule %s FIREWALL-EGRESS oif lo accept\n", table)
+		fmt.Fprintf(&buf, "add rule %s FIREWALL-EGRESS ct state established,related accept\n", table)
+
+		buf.Write(types.ToNFTables(*m, role, family))
+
+		fmt.Fprintf(&buf, "add rule %s FIREWALL log prefix \"firewall-%s \" drop\n", table, family)
+	}
+
+	return buf.Bytes()
+}
+
 func addFromFile(fp string, format types.Format) ([]types.ComDetails, error) {
 	var res []types.ComDetails
 	f, err := os.Open(filepath.Clean(fp))
@@ -221,24 +329,182 @@ func addFromFile(fp string, format types.Format) ([]types.ComDetails, error) {
 	return res, nil
 }
 
-func getStaticEntries(e Env, d Deployment) ([]types.ComDetails, error) {
-	comDetails := []types.ComDetails{}
+// EnvironmentProvider supplies the static, non-discoverable communication
+// entries for a cloud or bare-metal platform, and knows how to recognize
+// that platform on a live cluster. Register a provider with Register to
+// make it selectable via New's envName argument or via auto-detection.
+type EnvironmentProvider interface {
+	// Name is the value passed as New's envName to select this provider,
+	// and the value reported by RegisteredProviders.
+	Name() string
+	// StaticEntries returns the platform-specific entries for the given
+	// deployment topology.
+	StaticEntries(d Deployment) ([]types.ComDetails, error)
+	// Detect reports whether cs is connected to a cluster running on this
+	// platform, typically by inspecting the Infrastructure CR.
+	Detect(cs *client.ClientSet) (bool, error)
+}
+
+var providerRegistry = map[string]EnvironmentProvider{}
+
+// Register adds p to the set of environment providers New can select by
+// name or auto-detect. Registering a provider under a name that is already
+// taken replaces the previous one.
+func Register(p EnvironmentProvider) {
+	providerRegistry[p.Name()] = p
+}
+
+// RegisteredProviders returns the names of all registered environment
+// providers, sorted alphabetically.
+func RegisteredProviders() []string {
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	Register(&baremetalProvider{})
+	Register(&awsProvider{})
+	Register(&azureProvider{})
+	Register(&gcpProvider{})
+	Register(&vsphereProvider{})
+}
+
+type baremetalProvider struct{}
+
+func (baremetalProvider) Name() string { return "baremetal" }
+
+func (baremetalProvider) StaticEntries(d Deployment) ([]types.ComDetails, error) {
+	entries := append([]types.ComDetails{}, baremetalStaticEntriesMaster...)
+	if d == SNO {
+		return entries, nil
+	}
+
+	return append(entries, baremetalStaticEntriesWorker...), nil
+}
+
+func (baremetalProvider) Detect(cs *client.ClientSet) (bool, error) {
+	return detectPlatformType(cs, "BareMetal")
+}
+
+type awsProvider struct{}
+
+func (awsProvider) Name() string { return "aws" }
+
+func (awsProvider) StaticEntries(d Deployment) ([]types.ComDetails, error) {
+	entries := append([]types.ComDetails{}, awsCloudStaticEntriesMaster...)
+	if d == SNO {
+		return entries, nil
+	}
 
-	switch e {
-	case Baremetal:
-		comDetails = append(comDetails, baremetalStaticEntriesMaster...)
-		if d == SNO {
-			break
+	return append(entries, awsCloudStaticEntriesWorker...), nil
+}
+
+func (awsProvider) Detect(cs *client.ClientSet) (bool, error) {
+	return detectPlatformType(cs, "AWS")
+}
+
+type azureProvider struct{}
+
+func (azureProvider) Name() string { return "azure" }
+
+func (azureProvider) StaticEntries(d Deployment) ([]types.ComDetails, error) {
+	entries := append([]types.ComDetails{}, azureCloudStaticEntriesMaster...)
+	if d == SNO {
+		return entries, nil
+	}
+
+	return append(entries, azureCloudStaticEntriesWorker...), nil
+}
+
+func (azureProvider) Detect(cs *client.ClientSet) (bool, error) {
+	return detectPlatformType(cs, "Azure")
+}
+
+type gcpProvider struct{}
+
+func (gcpProvider) Name() string { return "gcp" }
+
+func (gcpProvider) StaticEntries(d Deployment) ([]types.ComDetails, error) {
+	entries := append([]types.ComDetails{}, gcpCloudStaticEntriesMaster...)
+	if d == SNO {
+		return entries, nil
+	}
+
+	return append(entries, gcpCloudStaticEntriesWorker...), nil
+}
+
+func (gcpProvider) Detect(cs *client.ClientSet) (bool, error) {
+	return detectPlatformType(cs, "GCP")
+}
+
+type vsphereProvider struct{}
+
+func (vsphereProvider) Name() string { return "vsphere" }
+
+func (vsphereProvider) StaticEntries(d Deployment) ([]types.ComDetails, error) {
+	entries := append([]types.ComDetails{}, vsphereStaticEntriesMaster...)
+	if d == SNO {
+		return entries, nil
+	}
+
+	return append(entries, vsphereStaticEntriesWorker...), nil
+}
+
+func (vsphereProvider) Detect(cs *client.ClientSet) (bool, error) {
+	return detectPlatformType(cs, "VSphere")
+}
+
+// detectPlatformType reports whether the cluster's Infrastructure CR
+// advertises platformType.
+func detectPlatformType(cs *client.ClientSet, platformType string) (bool, error) {
+	infra, err := cs.ConfigV1Interface.Infrastructures().Get(context.TODO(), "cluster", metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed getting Infrastructure CR: %w", err)
+	}
+
+	return string(infra.Status.PlatformStatus.Type) == platformType, nil
+}
+
+// resolveProvider returns the provider registered under envName, or, if
+// envName is empty, the first registered provider whose Detect matches cs.
+func resolveProvider(cs *client.ClientSet, envName string) (EnvironmentProvider, error) {
+	if envName != "" {
+		p, ok := providerRegistry[envName]
+		if !ok {
+			return nil, fmt.Errorf("unknown cluster environment %q, registered environments: %v", envName, RegisteredProviders())
 		}
-		comDetails = append(comDetails, baremetalStaticEntriesWorker...)
-	case AWS:
-		comDetails = append(comDetails, awsCloudStaticEntriesMaster...)
-		if d == SNO {
-			break
+
+		return p, nil
+	}
+
+	for _, name := range RegisteredProviders() {
+		p := providerRegistry[name]
+		ok, err := p.Detect(cs)
+		if err != nil {
+			return nil, fmt.Errorf("failed detecting environment %q: %w", p.Name(), err)
 		}
-		comDetails = append(comDetails, awsCloudStaticEntriesWorker...)
-	default:
-		return nil, fmt.Errorf("invalid value for cluster environment")
+		if ok {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to auto-detect cluster environment, specify one of: %v", RegisteredProviders())
+}
+
+func getStaticEntries(cs *client.ClientSet, envName string, d Deployment) ([]types.ComDetails, error) {
+	provider, err := resolveProvider(cs, envName)
+	if err != nil {
+		return nil, err
+	}
+
+	comDetails, err := provider.StaticEntries(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting static entries for environment %q: %w", provider.Name(), err)
 	}
 
 	comDetails = append(comDetails, generalStaticEntriesMaster...)
@@ -0,0 +1,32 @@
+package commatrix
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/openshift-kni/commatrix/types"
+)
+
+func TestRegisteredProvidersSorted(t *testing.T) {
+	names := RegisteredProviders()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("RegisteredProviders() = %v, want sorted", names)
+	}
+}
+
+func TestRenderFireWallRulesetIdempotent(t *testing.T) {
+	m := &types.ComMatrix{Matrix: []types.ComDetails{
+		{Direction: types.Ingress, Protocol: "TCP", Port: 22, NodeRole: "master", IPFamily: types.DualStack},
+	}}
+
+	rules := string(renderFireWallRuleset(m, "master"))
+
+	if strings.Contains(rules, "jump FIREWALL") {
+		t.Errorf("ruleset should hook FIREWALL directly rather than jump to it:\n%s", rules)
+	}
+
+	if n := strings.Count(rules, "add chain ip filter FIREWALL {"); n != 1 {
+		t.Errorf("expected FIREWALL to be declared with its hook exactly once per family, got %d:\n%s", n, rules)
+	}
+}
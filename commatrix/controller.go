@@ -0,0 +1,216 @@
+package commatrix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/openshift-kni/commatrix/client"
+)
+
+// defaultDebounceInterval is how long Run waits after the last informer
+// event before regenerating the matrix, so a rollout's burst of
+// EndpointSlice/Node/Service changes collapses into a single reconcile.
+const defaultDebounceInterval = 5 * time.Second
+
+// Options configures a controller run started with Run.
+type Options struct {
+	KubeconfigPath      string
+	CustomEntriesPath   string
+	CustomEntriesFormat string
+	Env                 string
+	Deployment          Deployment
+
+	// DebounceInterval defaults to 5s when zero.
+	DebounceInterval time.Duration
+
+	// MetricsAddr is where /metrics and /healthz are served, e.g. ":8081".
+	MetricsAddr string
+
+	LeaderElectionNamespace string
+	LeaderElectionID        string
+}
+
+var (
+	matrixSizeMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "commatrix_matrix_size",
+		Help: "Number of entries in the last generated communication matrix.",
+	})
+	lastReconcileTimestampMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "commatrix_last_reconcile_timestamp_seconds",
+		Help: "Unix timestamp of the last completed reconcile.",
+	})
+	applyErrorsTotalMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "commatrix_apply_errors_total",
+		Help: "Firewall apply errors, by node role.",
+	}, []string{"role"})
+	driftDetectedTotalMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "commatrix_drift_detected_total",
+		Help: "Number of times a node role's rendered ruleset differed from the last applied one.",
+	})
+)
+
+// Run starts commatrix as a controller: it watches EndpointSlices, Nodes and
+// Services, regenerates the matrix on change (debounced), and re-applies the
+// firewall only when the rendered ruleset drifted from the last applied one.
+// It serves /metrics and /healthz on opts.MetricsAddr, and only reconciles
+// while holding leadership, so it is safe to run as a multi-replica
+// Deployment. Run blocks until ctx is canceled or this replica loses
+// leadership after having held it, and then returns nil.
+func Run(ctx context.Context, opts Options) error {
+	cs, err := client.New(opts.KubeconfigPath)
+	if err != nil {
+		return fmt.Errorf("failed creating the k8s client: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := &http.Server{Addr: opts.MetricsAddr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("commatrix: metrics server stopped: %v\n", err)
+		}
+	}()
+	defer func() {
+		//nolint:errcheck // best-effort shutdown on context cancellation
+		srv.Shutdown(context.Background())
+	}()
+
+	identity, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed generating leader-election identity: %w", err)
+	}
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		opts.LeaderElectionNamespace,
+		opts.LeaderElectionID,
+		cs.CoreV1Interface,
+		cs.CoordinationV1Interface,
+		resourcelock.ResourceLockConfig{Identity: identity.String()},
+	)
+	if err != nil {
+		return fmt.Errorf("failed creating leader-election lock: %w", err)
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				runReconcileLoop(ctx, cs, opts)
+			},
+			OnStoppedLeading: func() {
+				fmt.Printf("commatrix: %s stopped leading\n", identity)
+			},
+		},
+	})
+
+	return nil
+}
+
+// runReconcileLoop installs the SharedInformers, debounces their events, and
+// reconciles the matrix until ctx is canceled.
+func runReconcileLoop(ctx context.Context, cs *client.ClientSet, opts Options) {
+	factory := informers.NewSharedInformerFactory(cs.KubernetesInterface, 0)
+	epSliceInformer := factory.Discovery().V1().EndpointSlices().Informer()
+	nodeInformer := factory.Core().V1().Nodes().Informer()
+	svcInformer := factory.Core().V1().Services().Informer()
+
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(_, _ interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}
+	//nolint:errcheck // AddEventHandler only fails if the informer already stopped
+	epSliceInformer.AddEventHandler(handler)
+	//nolint:errcheck
+	nodeInformer.AddEventHandler(handler)
+	//nolint:errcheck
+	svcInformer.AddEventHandler(handler)
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	debounce := opts.DebounceInterval
+	if debounce == 0 {
+		debounce = defaultDebounceInterval
+	}
+
+	lastRuleset := map[string][]byte{}
+	reconcile := func() {
+		if err := reconcileOnce(cs, opts, lastRuleset); err != nil {
+			fmt.Printf("commatrix: reconcile failed: %v\n", err)
+		}
+	}
+
+	reconcile()
+
+	var timer *time.Timer
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(debounce, reconcile)
+		}
+	}
+}
+
+// reconcileOnce regenerates the matrix, renders its ruleset for every role,
+// and re-applies the firewall only for roles whose rendered ruleset changed
+// since the last successful reconcile. lastRuleset is updated in place.
+func reconcileOnce(cs *client.ClientSet, opts Options, lastRuleset map[string][]byte) error {
+	mat, err := New(opts.KubeconfigPath, opts.CustomEntriesPath, opts.CustomEntriesFormat, opts.Env, opts.Deployment)
+	if err != nil {
+		return fmt.Errorf("failed regenerating the communication matrix: %w", err)
+	}
+
+	matrixSizeMetric.Set(float64(len(mat.Matrix)))
+
+	for _, role := range []string{"master", "worker"} {
+		rendered := DryRunFirewall(mat, role)
+		if bytes.Equal(lastRuleset[role], rendered) {
+			continue
+		}
+
+		driftDetectedTotalMetric.Inc()
+
+		if _, err := ApplyFireWallRules(cs, mat, role); err != nil {
+			applyErrorsTotalMetric.WithLabelValues(role).Inc()
+			return fmt.Errorf("failed applying firewall rules for role %s: %w", role, err)
+		}
+
+		lastRuleset[role] = rendered
+	}
+
+	lastReconcileTimestampMetric.SetToCurrentTime()
+
+	return nil
+}
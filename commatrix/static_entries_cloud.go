@@ -0,0 +1,75 @@
+package commatrix
+
+import "github.com/openshift-kni/commatrix/types"
+
+// Static entries for the cloud providers added by the EnvironmentProvider
+// registry: Azure, GCP and vSphere. Each mirrors the shape of the
+// pre-existing baremetal/AWS static entries - the platform's own
+// infra-facing listeners that aren't discoverable from EndpointSlices.
+
+var azureCloudStaticEntriesMaster = []types.ComDetails{
+	{
+		Direction: types.Ingress,
+		Protocol:  "TCP",
+		Port:      32000,
+		Namespace: "openshift-azure",
+		Service:   "azure-load-balancer-health-probe",
+		NodeRole:  "master",
+		Optional:  false,
+		IPFamily:  types.DualStack,
+	},
+}
+
+var azureCloudStaticEntriesWorker = []types.ComDetails{
+	{
+		Direction: types.Ingress,
+		Protocol:  "TCP",
+		Port:      32000,
+		Namespace: "openshift-azure",
+		Service:   "azure-load-balancer-health-probe",
+		NodeRole:  "worker",
+		Optional:  false,
+		IPFamily:  types.DualStack,
+	},
+}
+
+var gcpCloudStaticEntriesMaster = []types.ComDetails{
+	{
+		Direction: types.Egress,
+		Protocol:  "TCP",
+		Port:      80,
+		Namespace: "openshift-gcp",
+		Service:   "gcp-metadata-server",
+		NodeRole:  "master",
+		Optional:  false,
+		IPFamily:  types.IPv4,
+	},
+}
+
+var gcpCloudStaticEntriesWorker = []types.ComDetails{
+	{
+		Direction: types.Egress,
+		Protocol:  "TCP",
+		Port:      80,
+		Namespace: "openshift-gcp",
+		Service:   "gcp-metadata-server",
+		NodeRole:  "worker",
+		Optional:  false,
+		IPFamily:  types.IPv4,
+	},
+}
+
+var vsphereStaticEntriesMaster = []types.ComDetails{
+	{
+		Direction: types.Egress,
+		Protocol:  "TCP",
+		Port:      443,
+		Namespace: "openshift-vsphere-infra",
+		Service:   "vcenter-api",
+		NodeRole:  "master",
+		Optional:  false,
+		IPFamily:  types.IPv4,
+	},
+}
+
+var vsphereStaticEntriesWorker = []types.ComDetails{}
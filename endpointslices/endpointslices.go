@@ -0,0 +1,185 @@
+// Package endpointslices discovers ingress and egress communication
+// requirements from the cluster's EndpointSlice, NetworkPolicy and Node
+// objects.
+package endpointslices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/openshift-kni/commatrix/client"
+	"github.com/openshift-kni/commatrix/types"
+)
+
+// GetEgressComDetails derives egress ComDetails from every NetworkPolicy's
+// Egress rules. It covers only egress declared in NetworkPolicies; outbound
+// sockets open on the nodes are not inspected.
+func GetEgressComDetails(cs *client.ClientSet) ([]types.ComDetails, error) {
+	policies, err := cs.NetworkingV1Interface.NetworkPolicies(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing network policies: %w", err)
+	}
+
+	res := make([]types.ComDetails, 0)
+	for _, policy := range policies.Items {
+		for _, egress := range policy.Spec.Egress {
+			for _, port := range egress.Ports {
+				if port.Port == nil {
+					continue
+				}
+				// Named ports (e.g. a policy referencing a Service's "https"
+				// port) can only be resolved against the target pods'
+				// container specs, which this NetworkPolicy-only pass
+				// doesn't have; skip them rather than emitting a bogus
+				// Port: 0 entry.
+				if port.Port.Type == intstr.String {
+					continue
+				}
+
+				protocol := "TCP"
+				if port.Protocol != nil && *port.Protocol == corev1.ProtocolUDP {
+					protocol = "UDP"
+				}
+
+				res = append(res, types.ComDetails{
+					Direction: types.Egress,
+					Protocol:  protocol,
+					Port:      port.Port.IntValue(),
+					Namespace: policy.Namespace,
+					Service:   policy.Name,
+					NodeRole:  "worker",
+					IPFamily:  types.DualStack,
+				})
+			}
+		}
+	}
+
+	return types.CleanComDetails(res), nil
+}
+
+// IngressEndpointSliceInfo pairs an ingress EndpointSlice with the Service
+// name that owns it.
+type IngressEndpointSliceInfo struct {
+	EndpointSlice discoveryv1.EndpointSlice
+	ServiceName   string
+	Namespace     string
+}
+
+// serviceNameLabel is the well-known label EndpointSlices carry pointing
+// back at their owning Service.
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// GetIngressEndpointSlicesInfo lists every EndpointSlice in the cluster and
+// pairs it with its owning Service name.
+func GetIngressEndpointSlicesInfo(cs *client.ClientSet) ([]IngressEndpointSliceInfo, error) {
+	epSlices, err := cs.DiscoveryV1Interface.EndpointSlices(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing endpointslices: %w", err)
+	}
+
+	res := make([]IngressEndpointSliceInfo, 0, len(epSlices.Items))
+	for _, epSlice := range epSlices.Items {
+		res = append(res, IngressEndpointSliceInfo{
+			EndpointSlice: epSlice,
+			ServiceName:   epSlice.Labels[serviceNameLabel],
+			Namespace:     epSlice.Namespace,
+		})
+	}
+
+	return res, nil
+}
+
+// ToComDetails converts epSlicesInfo into ingress ComDetails entries. Each
+// entry's IPFamily comes from its EndpointSlice's AddressType; EndpointSlices
+// whose AddressType doesn't map to a family (e.g. AddressTypeFQDN) fall back
+// to the cluster's own address-family mix, from node InternalIP addresses.
+func ToComDetails(cs *client.ClientSet, epSlicesInfo []IngressEndpointSliceInfo) ([]types.ComDetails, error) {
+	fallbackFamily, err := clusterIPFamily(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]types.ComDetails, 0)
+	for _, info := range epSlicesInfo {
+		family := ipFamilyFromAddressType(info.EndpointSlice.AddressType)
+		if family == "" {
+			family = fallbackFamily
+		}
+
+		for _, port := range info.EndpointSlice.Ports {
+			if port.Port == nil {
+				continue
+			}
+
+			protocol := "TCP"
+			if port.Protocol != nil && *port.Protocol == corev1.ProtocolUDP {
+				protocol = "UDP"
+			}
+
+			res = append(res, types.ComDetails{
+				Direction: types.Ingress,
+				Protocol:  protocol,
+				Port:      int(*port.Port),
+				Namespace: info.Namespace,
+				Service:   info.ServiceName,
+				NodeRole:  "worker",
+				IPFamily:  family,
+			})
+		}
+	}
+
+	return res, nil
+}
+
+// ipFamilyFromAddressType maps an EndpointSlice's AddressType to the
+// IPFamily it represents. It returns the empty IPFamily for address types
+// (e.g. AddressTypeFQDN) that don't map to a single IP family.
+func ipFamilyFromAddressType(addrType discoveryv1.AddressType) types.IPFamily {
+	switch addrType {
+	case discoveryv1.AddressTypeIPv4:
+		return types.IPv4
+	case discoveryv1.AddressTypeIPv6:
+		return types.IPv6
+	default:
+		return ""
+	}
+}
+
+// clusterIPFamily inspects every node's InternalIP addresses to tell
+// whether the cluster is single-stack v4, single-stack v6, or dual-stack.
+func clusterIPFamily(cs *client.ClientSet) (types.IPFamily, error) {
+	nodesList, err := cs.CoreV1Interface.Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed listing nodes: %w", err)
+	}
+
+	sawV4, sawV6 := false, false
+	for _, node := range nodesList.Items {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type != corev1.NodeInternalIP {
+				continue
+			}
+
+			if strings.Contains(addr.Address, ":") {
+				sawV6 = true
+			} else {
+				sawV4 = true
+			}
+		}
+	}
+
+	switch {
+	case sawV4 && sawV6:
+		return types.DualStack, nil
+	case sawV6:
+		return types.IPv6, nil
+	default:
+		return types.IPv4, nil
+	}
+}
@@ -0,0 +1,26 @@
+package endpointslices
+
+import (
+	"testing"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+
+	"github.com/openshift-kni/commatrix/types"
+)
+
+func TestIPFamilyFromAddressType(t *testing.T) {
+	cases := []struct {
+		addrType discoveryv1.AddressType
+		want     types.IPFamily
+	}{
+		{discoveryv1.AddressTypeIPv4, types.IPv4},
+		{discoveryv1.AddressTypeIPv6, types.IPv6},
+		{discoveryv1.AddressTypeFQDN, ""},
+	}
+
+	for _, c := range cases {
+		if got := ipFamilyFromAddressType(c.addrType); got != c.want {
+			t.Errorf("ipFamilyFromAddressType(%v) = %q, want %q", c.addrType, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package hostnet discovers communication requirements that never show up
+// as a Service/EndpointSlice: pods running with spec.hostNetwork=true
+// (kubelet, CRI-O, OVN-Kubernetes, the Machine Config Server, keepalived,
+// NodePort listeners, ...) bind ports directly on the node, so they are
+// invisible to endpointslices.GetIngressEndpointSlicesInfo.
+package hostnet
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift-kni/commatrix/client"
+	nodesutil "github.com/openshift-kni/commatrix/nodes"
+	"github.com/openshift-kni/commatrix/types"
+)
+
+// GetHostNetworkComDetails lists every host-networked pod in the cluster,
+// resolves its container ports, and returns the corresponding ingress
+// ComDetails entries.
+func GetHostNetworkComDetails(cs *client.ClientSet) ([]types.ComDetails, error) {
+	pods, err := cs.CoreV1Interface.Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing pods: %w", err)
+	}
+
+	nodesList, err := cs.CoreV1Interface.Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing nodes: %w", err)
+	}
+	nodeRoles := make(map[string]string, len(nodesList.Items))
+	for _, n := range nodesList.Items {
+		node := n
+		nodeRoles[node.Name] = nodesutil.GetRole(&node)
+	}
+
+	res := make([]types.ComDetails, 0)
+	for _, pod := range pods.Items {
+		if !pod.Spec.HostNetwork {
+			continue
+		}
+
+		res = append(res, comDetailsFromPod(&pod, nodeRoles[pod.Spec.NodeName])...)
+	}
+
+	return res, nil
+}
+
+func comDetailsFromPod(pod *corev1.Pod, nodeRole string) []types.ComDetails {
+	res := make([]types.ComDetails, 0)
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			protocol := "TCP"
+			switch port.Protocol {
+			case corev1.ProtocolUDP:
+				protocol = "UDP"
+			case corev1.ProtocolSCTP:
+				protocol = "SCTP"
+			}
+
+			res = append(res, types.ComDetails{
+				Direction: types.Ingress,
+				Protocol:  protocol,
+				Port:      int(port.ContainerPort),
+				Namespace: pod.Namespace,
+				Service:   pod.Name,
+				Pod:       pod.Name,
+				Container: container.Name,
+				NodeRole:  nodeRole,
+				Optional:  false,
+				// Host-network ports bind on whatever addresses the node
+				// itself has; without per-node address-family data here,
+				// treat them as applying to both families so they survive
+				// ToNFTables/renderFireWallRuleset for both.
+				IPFamily: types.DualStack,
+			})
+		}
+	}
+
+	return res
+}
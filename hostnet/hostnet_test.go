@@ -0,0 +1,60 @@
+package hostnet
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift-kni/commatrix/types"
+)
+
+func TestComDetailsFromPodProtocols(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "keepalived", Namespace: "openshift-vip"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "keepalived",
+				Ports: []corev1.ContainerPort{
+					{ContainerPort: 80},
+					{ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+					{ContainerPort: 9999, Protocol: corev1.ProtocolSCTP},
+				},
+			}},
+		},
+	}
+
+	got := comDetailsFromPod(pod, "master")
+
+	want := map[int]string{80: "TCP", 53: "UDP", 9999: "SCTP"}
+	if len(got) != len(want) {
+		t.Fatalf("comDetailsFromPod() returned %d entries, want %d", len(got), len(want))
+	}
+	for _, cd := range got {
+		if cd.Protocol != want[cd.Port] {
+			t.Errorf("port %d: protocol = %q, want %q", cd.Port, cd.Protocol, want[cd.Port])
+		}
+	}
+}
+
+func TestComDetailsFromPodSurvivesBothFamilies(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubelet", Namespace: "kube-system"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "kubelet",
+				Ports: []corev1.ContainerPort{{ContainerPort: 10250}},
+			}},
+		},
+	}
+
+	m := types.ComMatrix{Matrix: comDetailsFromPod(pod, "master")}
+
+	for _, family := range []types.IPFamily{types.IPv4, types.IPv6} {
+		rules := string(types.ToNFTables(m, "master", family))
+		if !strings.Contains(rules, "tcp dport { 10250 } accept") {
+			t.Errorf("family %s: host-network entry dropped from ToNFTables, got:\n%s", family, rules)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"encoding/json"
+
+	"github.com/gocarina/gocsv"
+	"sigs.k8s.io/yaml"
+)
+
+// Severity classifies a single entry in a MatrixDiff.
+type Severity string
+
+const (
+	// SeverityUnexpectedOpen marks a flow observed on a node that nothing in
+	// the expected matrix accounts for.
+	SeverityUnexpectedOpen Severity = "unexpected-open"
+	// SeverityMissingListener marks a flow the expected matrix requires but
+	// that wasn't observed on the node.
+	SeverityMissingListener Severity = "missing-listener"
+	// SeverityKnownNoise marks an unexpected flow whose service name appears
+	// in the caller-supplied allowlist.
+	SeverityKnownNoise Severity = "known-noise"
+	// SeverityCommon marks a Common entry in a flattened (CSV) rendering.
+	// It's never produced by NewMatrixDiff's own classification - Common
+	// entries carry no severity of their own - it only exists so ToDiffCSV
+	// can give every row a value in the SEVERITY column.
+	SeverityCommon Severity = "common"
+)
+
+// DiffEntry pairs a ComDetails flow with the Severity it was classified as.
+type DiffEntry struct {
+	ComDetails ComDetails `json:"comDetails" yaml:"comDetails"`
+	Severity   Severity   `json:"severity" yaml:"severity"`
+}
+
+// MatrixDiff is the classified difference between an expected and an
+// observed ComMatrix: Added holds flows observed but not expected, Removed
+// holds flows expected but not observed, and Common holds flows present in
+// both.
+type MatrixDiff struct {
+	Added   []DiffEntry  `json:"added" yaml:"added"`
+	Removed []DiffEntry  `json:"removed" yaml:"removed"`
+	Common  []ComDetails `json:"common" yaml:"common"`
+}
+
+// NewMatrixDiff compares expected against observed and classifies every
+// flow into Added, Removed or Common. Added flows whose Service name is in
+// allowlist are classified as known noise rather than an unexpected open
+// port. Optional expected flows that weren't observed are dropped rather
+// than reported as Removed.
+func NewMatrixDiff(expected, observed ComMatrix, allowlist []string) MatrixDiff {
+	allowed := make(map[string]bool, len(allowlist))
+	for _, service := range allowlist {
+		allowed[service] = true
+	}
+
+	diff := MatrixDiff{}
+
+	for _, cd := range observed.Matrix {
+		if expected.Contains(cd) {
+			continue
+		}
+
+		severity := SeverityUnexpectedOpen
+		if allowed[cd.Service] {
+			severity = SeverityKnownNoise
+		}
+		diff.Added = append(diff.Added, DiffEntry{ComDetails: cd, Severity: severity})
+	}
+
+	for _, cd := range expected.Matrix {
+		if observed.Contains(cd) {
+			diff.Common = append(diff.Common, cd)
+			continue
+		}
+		if cd.Optional {
+			continue
+		}
+
+		diff.Removed = append(diff.Removed, DiffEntry{ComDetails: cd, Severity: SeverityMissingListener})
+	}
+
+	return diff
+}
+
+// HasSeverity reports whether diff has at least one Added or Removed entry
+// classified as severity.
+func (diff MatrixDiff) HasSeverity(severity Severity) bool {
+	for _, entry := range diff.Added {
+		if entry.Severity == severity {
+			return true
+		}
+	}
+	for _, entry := range diff.Removed {
+		if entry.Severity == severity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diffRow flattens a DiffEntry (or a Common ComDetails, given SeverityCommon)
+// for CSV output, since gocsv can't marshal the embedded ComDetails and
+// Severity columns together otherwise.
+type diffRow struct {
+	ComDetails
+	Severity Severity `csv:"SEVERITY"`
+}
+
+// ToDiffJSON marshals diff as indented JSON.
+func ToDiffJSON(diff MatrixDiff) ([]byte, error) {
+	return json.MarshalIndent(diff, "", "  ")
+}
+
+// ToDiffYAML marshals diff as YAML.
+func ToDiffYAML(diff MatrixDiff) ([]byte, error) {
+	return yaml.Marshal(diff)
+}
+
+// ToDiffCSV marshals diff as a single flat CSV, with the Severity column
+// alongside the usual ComDetails columns.
+func ToDiffCSV(diff MatrixDiff) ([]byte, error) {
+	rows := make([]diffRow, 0, len(diff.Added)+len(diff.Removed)+len(diff.Common))
+	for _, entry := range diff.Added {
+		rows = append(rows, diffRow{ComDetails: entry.ComDetails, Severity: entry.Severity})
+	}
+	for _, entry := range diff.Removed {
+		rows = append(rows, diffRow{ComDetails: entry.ComDetails, Severity: entry.Severity})
+	}
+	for _, cd := range diff.Common {
+		rows = append(rows, diffRow{ComDetails: cd, Severity: SeverityCommon})
+	}
+
+	return gocsv.MarshalBytes(rows)
+}
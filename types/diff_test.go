@@ -0,0 +1,59 @@
+package types
+
+import "testing"
+
+func TestNewMatrixDiff(t *testing.T) {
+	expected := ComMatrix{Matrix: []ComDetails{
+		{Direction: Ingress, Protocol: "TCP", Port: 22, NodeRole: "master", Service: "sshd"},
+		{Direction: Ingress, Protocol: "TCP", Port: 6443, NodeRole: "master", Service: "apiserver", Optional: true},
+	}}
+	observed := ComMatrix{Matrix: []ComDetails{
+		{Direction: Ingress, Protocol: "TCP", Port: 22, NodeRole: "master", Service: "sshd"},
+		{Direction: Ingress, Protocol: "TCP", Port: 9100, NodeRole: "master", Service: "node-exporter"},
+		{Direction: Ingress, Protocol: "TCP", Port: 31337, NodeRole: "master", Service: "backdoor"},
+	}}
+
+	diff := NewMatrixDiff(expected, observed, []string{"node-exporter"})
+
+	if len(diff.Common) != 1 || diff.Common[0].Service != "sshd" {
+		t.Errorf("Common = %+v, want just the sshd entry", diff.Common)
+	}
+
+	if diff.HasSeverity(SeverityMissingListener) {
+		t.Errorf("optional expected-only entry should not count as missing, got removed: %+v", diff.Removed)
+	}
+
+	if !diff.HasSeverity(SeverityKnownNoise) {
+		t.Error("allowlisted unexpected entry should be classified as known noise")
+	}
+
+	if !diff.HasSeverity(SeverityUnexpectedOpen) {
+		t.Error("non-allowlisted unexpected entry should be classified as unexpected-open")
+	}
+
+	var backdoorSeverity Severity
+	for _, entry := range diff.Added {
+		if entry.ComDetails.Service == "backdoor" {
+			backdoorSeverity = entry.Severity
+		}
+	}
+	if backdoorSeverity != SeverityUnexpectedOpen {
+		t.Errorf("backdoor entry severity = %q, want %q", backdoorSeverity, SeverityUnexpectedOpen)
+	}
+}
+
+func TestNewMatrixDiffMissingListener(t *testing.T) {
+	expected := ComMatrix{Matrix: []ComDetails{
+		{Direction: Ingress, Protocol: "TCP", Port: 22, NodeRole: "master", Service: "sshd"},
+	}}
+	observed := ComMatrix{}
+
+	diff := NewMatrixDiff(expected, observed, nil)
+
+	if !diff.HasSeverity(SeverityMissingListener) {
+		t.Error("expected-but-not-observed entry should be classified as missing-listener")
+	}
+	if len(diff.Common) != 0 {
+		t.Errorf("Common = %+v, want empty", diff.Common)
+	}
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ToNFTables renders the accept rules for role's entries in family (entries
+// tagged DualStack are included for both IPv4 and IPv6) as nft rule lines.
+// It assumes the caller has already created the `FIREWALL` and
+// `FIREWALL-EGRESS` chains it appends to.
+func ToNFTables(m ComMatrix, role string, family IPFamily) []byte {
+	table := "ip filter"
+	if family == IPv6 {
+		table = "ip6 filter"
+	}
+
+	var buf bytes.Buffer
+
+	ports := portsByProtocol(m, role, family, Ingress)
+	writeAcceptRules(&buf, table, "FIREWALL", ports)
+
+	egressPorts := portsByProtocol(m, role, family, Egress)
+	writeAcceptRules(&buf, table, "FIREWALL-EGRESS", egressPorts)
+
+	return buf.Bytes()
+}
+
+// writeAcceptRules appends one `dport { ... } accept` rule per non-empty
+// protocol in ports to chain.
+func writeAcceptRules(buf *bytes.Buffer, table, chain string, ports map[string]string) {
+	for _, protocol := range []string{"tcp", "udp", "sctp"} {
+		dports := ports[protocol]
+		if dports == "" {
+			continue
+		}
+		fmt.Fprintf(buf, "add rule %s %s %s dport { %s } accept\n", table, chain, protocol, dports)
+	}
+}
+
+// portsByProtocol splits m's entries destined to role and family, in the
+// given direction, into comma-separated dport lists keyed by lowercase
+// protocol name ("tcp", "udp", "sctp"), ready to be interpolated into an
+// nft dport set.
+func portsByProtocol(m ComMatrix, role string, family IPFamily, direction Direction) map[string]string {
+	ports := map[string][]string{}
+
+	for _, cd := range m.Matrix {
+		if cd.NodeRole != role || cd.Direction != direction {
+			continue
+		}
+		if cd.IPFamily != family && cd.IPFamily != DualStack {
+			continue
+		}
+
+		protocol := strings.ToLower(cd.Protocol)
+		ports[protocol] = append(ports[protocol], strconv.Itoa(cd.Port))
+	}
+
+	res := make(map[string]string, len(ports))
+	for protocol, list := range ports {
+		res[protocol] = strings.Join(list, ", ")
+	}
+
+	return res
+}
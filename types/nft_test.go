@@ -0,0 +1,41 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestMatrix() ComMatrix {
+	return ComMatrix{Matrix: []ComDetails{
+		{Direction: Ingress, Protocol: "TCP", Port: 22, NodeRole: "master", IPFamily: DualStack},
+		{Direction: Ingress, Protocol: "UDP", Port: 53, NodeRole: "master", IPFamily: IPv4},
+		{Direction: Ingress, Protocol: "SCTP", Port: 9999, NodeRole: "master", IPFamily: IPv6},
+		{Direction: Egress, Protocol: "TCP", Port: 443, NodeRole: "master", IPFamily: DualStack},
+	}}
+}
+
+func TestToNFTables(t *testing.T) {
+	m := newTestMatrix()
+
+	v4 := string(ToNFTables(m, "master", IPv4))
+	if !strings.Contains(v4, "tcp dport { 22 } accept") {
+		t.Errorf("ipv4 output missing dual-stack tcp rule:\n%s", v4)
+	}
+	if !strings.Contains(v4, "udp dport { 53 } accept") {
+		t.Errorf("ipv4 output missing ipv4-only udp rule:\n%s", v4)
+	}
+	if strings.Contains(v4, "9999") {
+		t.Errorf("ipv4 output should not include the ipv6-only SCTP entry:\n%s", v4)
+	}
+	if !strings.Contains(v4, "FIREWALL-EGRESS tcp dport { 443 } accept") {
+		t.Errorf("ipv4 output missing egress rule:\n%s", v4)
+	}
+
+	v6 := string(ToNFTables(m, "master", IPv6))
+	if !strings.Contains(v6, "sctp dport { 9999 } accept") {
+		t.Errorf("ipv6 output missing ipv6-only sctp rule:\n%s", v6)
+	}
+	if strings.Contains(v6, "dport { 53 }") {
+		t.Errorf("ipv6 output should not include the ipv4-only udp entry:\n%s", v6)
+	}
+}
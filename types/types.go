@@ -0,0 +1,127 @@
+// Package types holds the data model shared across commatrix: the
+// communication-matrix entries themselves, their serialization formats, and
+// the nftables/diff renderers built on top of them.
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gocarina/gocsv"
+	"sigs.k8s.io/yaml"
+)
+
+// Direction classifies whether a ComDetails entry describes traffic a node
+// accepts (Ingress) or traffic a node originates (Egress).
+type Direction string
+
+const (
+	Ingress Direction = "Ingress"
+	Egress  Direction = "Egress"
+)
+
+// IPFamily records which IP address family (or both) a ComDetails entry
+// applies to.
+type IPFamily string
+
+const (
+	IPv4      IPFamily = "ipv4"
+	IPv6      IPFamily = "ipv6"
+	DualStack IPFamily = "dual"
+)
+
+// ComDetails describes a single required communication flow.
+type ComDetails struct {
+	Direction Direction `json:"direction" yaml:"direction" csv:"DIRECTION"`
+	Protocol  string    `json:"protocol" yaml:"protocol" csv:"PROTOCOL"`
+	Port      int       `json:"port" yaml:"port" csv:"PORT"`
+	Namespace string    `json:"namespace" yaml:"namespace" csv:"NAMESPACE"`
+	Service   string    `json:"service" yaml:"service" csv:"SERVICE"`
+	Pod       string    `json:"pod" yaml:"pod" csv:"POD"`
+	Container string    `json:"container" yaml:"container" csv:"CONTAINER"`
+	NodeRole  string    `json:"nodeRole" yaml:"nodeRole" csv:"NODE_ROLE"`
+	Optional  bool      `json:"optional" yaml:"optional" csv:"OPTIONAL"`
+	IPFamily  IPFamily  `json:"ipFamily" yaml:"ipFamily" csv:"IP_FAMILY"`
+}
+
+func (cd ComDetails) String() string {
+	return fmt.Sprintf("%s,%s,%d,%s,%s,%s,%s,%s,%t,%s",
+		cd.Direction, cd.Protocol, cd.Port, cd.Namespace, cd.Service, cd.Pod, cd.Container, cd.NodeRole, cd.Optional, cd.IPFamily)
+}
+
+// key identifies the flow a ComDetails entry describes, for dedup and diff
+// purposes. Pod/Container/Optional are deliberately excluded: two entries
+// that differ only in which pod happened to serve the request, or in
+// whether the flow is optional, are still the same required flow.
+func (cd ComDetails) key() string {
+	return strings.Join([]string{
+		string(cd.Direction), cd.Protocol, strconv.Itoa(cd.Port), cd.Namespace, cd.Service, cd.NodeRole,
+	}, "|")
+}
+
+// ComMatrix is an ordered collection of communication flows.
+type ComMatrix struct {
+	Matrix []ComDetails
+}
+
+// Contains reports whether m has an entry describing the same flow as cd.
+func (m ComMatrix) Contains(cd ComDetails) bool {
+	for _, existing := range m.Matrix {
+		if existing.key() == cd.key() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CleanComDetails removes duplicate flows from entries, keeping the first
+// occurrence of each.
+func CleanComDetails(entries []ComDetails) []ComDetails {
+	seen := make(map[string]bool, len(entries))
+	res := make([]ComDetails, 0, len(entries))
+
+	for _, cd := range entries {
+		k := cd.key()
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		res = append(res, cd)
+	}
+
+	return res
+}
+
+// Format identifies the serialization format of a custom-entries file.
+type Format int
+
+const (
+	JSON Format = iota
+	YAML
+	CSV
+	FormatErr
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatCSV  = "csv"
+)
+
+// ToJSON marshals m as indented JSON.
+func ToJSON(m ComMatrix) ([]byte, error) {
+	return json.MarshalIndent(m.Matrix, "", "  ")
+}
+
+// ToYAML marshals m as YAML.
+func ToYAML(m ComMatrix) ([]byte, error) {
+	return yaml.Marshal(m.Matrix)
+}
+
+// ToCSV marshals m as CSV.
+func ToCSV(m ComMatrix) ([]byte, error) {
+	return gocsv.MarshalBytes(m.Matrix)
+}